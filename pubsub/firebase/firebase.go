@@ -2,10 +2,16 @@ package firebase
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"math"
+	"math/rand"
 	"time"
 
 	"firebase.google.com/go/v4/messaging"
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/prometheus/client_golang/prometheus"
 	"go.uber.org/zap"
 	"gocloud.dev/gcerrors"
 	"gocloud.dev/pubsub"
@@ -27,16 +33,270 @@ type TopicOptions struct {
 	DryRun bool
 
 	BacherOptions *batcher.Options
+	// MetricService is the legacy StatsD-style metrics sink. Prefer
+	// Metrics for new callers; when both are set, Metrics takes
+	// precedence.
 	MetricService countMetricInterface
 	Tags          []string
 	Logger        *zap.Logger
+
+	// Metrics, if set, receives structured SendBatch metrics (latency,
+	// per-message outcome by error class, in-flight batch count, retry
+	// attempts). PrometheusMetrics is the provided implementation; Metrics
+	// takes precedence over MetricService when both are set.
+	Metrics Metrics
+
+	// DeadLetterTopic, if set, receives a DeadLetterEnvelope for every
+	// message whose failure is classified as terminal (see
+	// TerminalErrorClassifier) instead of letting it fail the batch.
+	DeadLetterTopic *pubsub.Topic
+	// MaxDeliveryAttempts bounds how many times a message may be attempted
+	// before a failure is treated as terminal regardless of its error
+	// class. Zero means classification is purely error-based.
+	MaxDeliveryAttempts int
+	// TerminalErrorClassifier decides whether a per-message failure is
+	// permanent, and therefore eligible for dead-lettering, rather than
+	// transient. Defaults to treating IsUnregistered, IsInvalidArgument
+	// and IsSenderIDMismatch as terminal.
+	TerminalErrorClassifier func(error) bool
+
+	// RetryPolicy governs per-message retries of responses whose error is
+	// retryable (see fcmTopic.IsRetryable), since the Firebase SDK only
+	// retries the whole SendAll HTTP call, not individual BatchResponse
+	// entries. Defaults to defaultRetryPolicy.
+	RetryPolicy *RetryPolicy
+
+	// Encoding selects how SendBatch decodes driver.Message.Body. Defaults
+	// to EncodingFCMJSON.
+	Encoding Encoding
+
+	// OnError, if set, is invoked exactly once for every message that still
+	// has an error after retries, including messages published to
+	// DeadLetterTopic, with its final SendResponse and error. Exactly one of
+	// AfterSend (on the driver.Message) or OnError fires per message.
+	OnError func(*messaging.SendResponse, error)
+}
+
+// Encoding selects how fcmTopic.SendBatch interprets driver.Message.Body.
+type Encoding int
+
+const (
+	// EncodingFCMJSON expects Body to be a JSON-marshaled messaging.Message
+	// (entry.UnmarshalJSON); the historical, and default, behavior.
+	EncodingFCMJSON Encoding = iota
+	// EncodingCloudEvent expects Body to be a structured CloudEvents JSON
+	// envelope whose data is a messaging.Message. Selected CloudEvents
+	// attributes (ce-id, ce-source, ce-type, ce-time, ce-subject) are
+	// copied into the resulting Message.Data so downstream clients can
+	// correlate notifications back to the originating event.
+	EncodingCloudEvent
+)
+
+// RetryPolicy configures exponential backoff for retrying the subset of a
+// SendBatch response that failed with a retryable error.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of times a message may be sent,
+	// including the initial attempt.
+	MaxAttempts int
+	// InitialBackoff is the delay before the first retry.
+	InitialBackoff time.Duration
+	// MaxBackoff caps the delay between retries.
+	MaxBackoff time.Duration
+	// Multiplier scales the backoff after each attempt.
+	Multiplier float64
+	// Jitter, when true, scales the computed backoff by a random factor
+	// in [0, 1) (full jitter) instead of sleeping the full duration.
+	Jitter bool
+}
+
+var defaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: 100 * time.Millisecond,
+	MaxBackoff:     5 * time.Second,
+	Multiplier:     2,
+	Jitter:         true,
+}
+
+// backoff returns the delay before the retry numbered attempt (1-indexed:
+// the first retry after the initial send is attempt 1).
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	d := float64(p.InitialBackoff) * math.Pow(p.Multiplier, float64(attempt-1))
+	if max := float64(p.MaxBackoff); d > max {
+		d = max
+	}
+	if p.Jitter {
+		d *= rand.Float64()
+	}
+	return time.Duration(d)
+}
+
+// Metrics is the structured metrics surface for the FCM pubsub driver. It
+// supersedes countMetricInterface with per-error-class outcome tracking
+// instead of flat timing/count calls. PrometheusMetrics is the provided
+// implementation, but any Metrics can be plugged in via
+// TopicOptions.Metrics.
+type Metrics interface {
+	// ObserveSendBatchLatency records the wall-clock duration of one
+	// SendBatch call.
+	ObserveSendBatchLatency(d time.Duration)
+	// ObserveMessageOutcome records a single message's result, keyed by
+	// class ("ok", "unregistered", "invalid_argument", "quota_exceeded",
+	// "unavailable", "internal", or "other").
+	ObserveMessageOutcome(class string)
+	// IncInFlightBatches/DecInFlightBatches bracket a SendBatch call.
+	IncInFlightBatches()
+	DecInFlightBatches()
+	// ObserveRetryAttempt records a retry of a transient per-message
+	// failure, keyed by the same class values as ObserveMessageOutcome.
+	ObserveRetryAttempt(class string)
+}
+
+// messageOutcomeClass maps a per-message FCM error to the class label used
+// by Metrics.ObserveMessageOutcome and Metrics.ObserveRetryAttempt.
+func messageOutcomeClass(err error) string {
+	if err == nil {
+		return "ok"
+	}
+	var iceErr *invalidCloudEventError
+	if errors.As(err, &iceErr) {
+		return "invalid_argument"
+	}
+	switch {
+	case messaging.IsUnregistered(err):
+		return "unregistered"
+	case messaging.IsInvalidArgument(err):
+		return "invalid_argument"
+	case messaging.IsQuotaExceeded(err):
+		return "quota_exceeded"
+	case messaging.IsUnavailable(err):
+		return "unavailable"
+	case messaging.IsInternal(err):
+		return "internal"
+	default:
+		return "other"
+	}
+}
+
+// legacyMetricsAdapter adapts the older countMetricInterface (Timing/Count
+// with tag-kv varargs) onto Metrics, so existing TopicOptions.MetricService
+// configurations keep working unchanged.
+type legacyMetricsAdapter struct {
+	legacy countMetricInterface
+	tags   []string
+}
+
+func (a *legacyMetricsAdapter) ObserveSendBatchLatency(d time.Duration) {
+	a.legacy.Timing("firebase.messagine.sendBatch.latency", d, a.tags...)
+}
+
+func (a *legacyMetricsAdapter) ObserveMessageOutcome(class string) {
+	if class == "ok" {
+		return
+	}
+	a.legacy.Count("firebase.message.sendBatch.failure", 1, a.tags...)
+}
+
+func (a *legacyMetricsAdapter) IncInFlightBatches()        {}
+func (a *legacyMetricsAdapter) DecInFlightBatches()        {}
+func (a *legacyMetricsAdapter) ObserveRetryAttempt(string) {}
+
+// noopMetrics is used when neither TopicOptions.Metrics nor
+// TopicOptions.MetricService is configured.
+type noopMetrics struct{}
+
+func (noopMetrics) ObserveSendBatchLatency(time.Duration) {}
+func (noopMetrics) ObserveMessageOutcome(string)          {}
+func (noopMetrics) IncInFlightBatches()                   {}
+func (noopMetrics) DecInFlightBatches()                   {}
+func (noopMetrics) ObserveRetryAttempt(string)            {}
+
+// fcmSender is the subset of *messaging.Client that fcmTopic depends on,
+// narrowed so tests can substitute a fake instead of talking to FCM.
+type fcmSender interface {
+	SendAll(ctx context.Context, messages []*messaging.Message) (*messaging.BatchResponse, error)
+	SendAllDryRun(ctx context.Context, messages []*messaging.Message) (*messaging.BatchResponse, error)
 }
 
 type fcmTopic struct {
-	client *messaging.Client
+	client fcmSender
 	opts   *TopicOptions
 }
 
+// metrics resolves the effective Metrics sink: TopicOptions.Metrics if set,
+// otherwise an adapter over TopicOptions.MetricService, otherwise a no-op.
+func (t *fcmTopic) metrics() Metrics {
+	if t.opts.Metrics != nil {
+		return t.opts.Metrics
+	}
+	if t.opts.MetricService != nil {
+		return &legacyMetricsAdapter{legacy: t.opts.MetricService, tags: t.opts.Tags}
+	}
+	return noopMetrics{}
+}
+
+// PrometheusMetrics is a Metrics implementation backed by
+// github.com/prometheus/client_golang collectors. Build it with
+// NewPrometheusMetrics against the application's own prometheus.Registerer
+// and assign it to TopicOptions.Metrics; scraping requires no further
+// wiring.
+type PrometheusMetrics struct {
+	latency       prometheus.Histogram
+	outcomes      *prometheus.CounterVec
+	inFlight      prometheus.Gauge
+	retryAttempts *prometheus.CounterVec
+}
+
+// NewPrometheusMetrics builds and registers the FCM driver's collectors
+// against reg.
+func NewPrometheusMetrics(reg prometheus.Registerer) (*PrometheusMetrics, error) {
+	pm := &PrometheusMetrics{
+		latency: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name: "firebase_sendbatch_latency_seconds",
+			Help: "Latency of fcmTopic.SendBatch calls to the Firebase Admin SDK.",
+			// 5ms .. ~5.12s, tuned for FCM's typical SendAll response times.
+			Buckets: prometheus.ExponentialBuckets(0.005, 2, 11),
+		}),
+		outcomes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "firebase_sendbatch_message_outcomes_total",
+			Help: "Per-message SendBatch outcomes, keyed by FCM error class.",
+		}, []string{"class"}),
+		inFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "firebase_sendbatch_in_flight_batches",
+			Help: "Number of SendBatch calls currently in flight.",
+		}),
+		retryAttempts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "firebase_sendbatch_retry_attempts_total",
+			Help: "Retries of transient per-message SendBatch failures, keyed by FCM error class.",
+		}, []string{"class"}),
+	}
+	for _, c := range []prometheus.Collector{pm.latency, pm.outcomes, pm.inFlight, pm.retryAttempts} {
+		if err := reg.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return pm, nil
+}
+
+func (pm *PrometheusMetrics) ObserveSendBatchLatency(d time.Duration) {
+	pm.latency.Observe(d.Seconds())
+}
+
+func (pm *PrometheusMetrics) ObserveMessageOutcome(class string) {
+	pm.outcomes.WithLabelValues(class).Inc()
+}
+
+func (pm *PrometheusMetrics) IncInFlightBatches() {
+	pm.inFlight.Inc()
+}
+
+func (pm *PrometheusMetrics) DecInFlightBatches() {
+	pm.inFlight.Dec()
+}
+
+func (pm *PrometheusMetrics) ObserveRetryAttempt(class string) {
+	pm.retryAttempts.WithLabelValues(class).Inc()
+}
+
 func OpenFCMTopic(ctx context.Context, client *messaging.Client, opts *TopicOptions) *pubsub.Topic {
 	bo := sendBatchOpts
 	if opts != nil && opts.BacherOptions != nil {
@@ -56,15 +316,23 @@ func openFCMTopic(ctx context.Context, client *messaging.Client, opts *TopicOpti
 }
 
 func (t *fcmTopic) SendBatch(ctx context.Context, dms []*driver.Message) error {
+	metrics := t.metrics()
 	now := time.Now()
+	metrics.IncInFlightBatches()
 	defer func() {
-		t.opts.MetricService.Timing("firebase.messagine.sendBatch.latency", time.Since(now), t.opts.Tags...)
+		metrics.ObserveSendBatchLatency(time.Since(now))
+		metrics.DecInFlightBatches()
 	}()
-	entries := make([]*messaging.Message, 0, len(dms))
-	for _, dm := range dms {
-		entry := &messaging.Message{}
-		if err := entry.UnmarshalJSON(dm.Body); err != nil {
-			return err
+	entries := make([]*messaging.Message, len(dms))
+	responses := make([]*messaging.SendResponse, len(dms))
+	sendIdx := make([]int, 0, len(dms))
+	for n, dm := range dms {
+		entry, decodeErr := t.decodeMessage(dm.Body)
+		if decodeErr != nil {
+			// A single malformed envelope (e.g. bad CloudEvents JSON)
+			// shouldn't tank sends for the rest of the batch.
+			responses[n] = &messaging.SendResponse{Success: false, Error: decodeErr}
+			continue
 		}
 		if dm.BeforeSend != nil {
 			asFunc := func(i interface{}) bool {
@@ -78,51 +346,50 @@ func (t *fcmTopic) SendBatch(ctx context.Context, dms []*driver.Message) error {
 				return err
 			}
 		}
-		entries = append(entries, entry)
-	}
-	var err error
-	var resp *messaging.BatchResponse
-	if t.opts.DryRun {
-		resp, err = t.client.SendAllDryRun(ctx, entries)
-	} else {
-		resp, err = t.client.SendAll(ctx, entries)
+		entries[n] = entry
+		sendIdx = append(sendIdx, n)
 	}
 
-	if err != nil {
-		t.opts.Logger.Error("Error from response entity", zap.String("from", "pubsub.firebase.sendBatch.response"), zap.Error(err))
-		return err
+	if len(sendIdx) > 0 {
+		sendEntries := make([]*messaging.Message, len(sendIdx))
+		for i, idx := range sendIdx {
+			sendEntries[i] = entries[idx]
+		}
+		sendResp, err := t.sendAll(ctx, sendEntries)
+		if err != nil {
+			t.opts.Logger.Error("Error from response entity", zap.String("from", "pubsub.firebase.sendBatch.response"), zap.Error(err))
+			return err
+		}
+		for i, idx := range sendIdx {
+			responses[idx] = sendResp.Responses[i]
+		}
 	}
 
-	if resp.FailureCount > 0 {
-		t.opts.MetricService.Count("firebase.message.sendBatch.failure", int64(resp.FailureCount), t.opts.Tags...)
+	resp := &messaging.BatchResponse{Responses: responses}
+	recomputeBatchCounts(resp)
+
+	// attempts tracks how many times each message has been sent to FCM,
+	// starting at 1 for the send above; retryTransientFailures increments it
+	// for every index it retries.
+	attempts := make([]int, len(dms))
+	for i := range attempts {
+		attempts[i] = 1
+	}
+	if err := t.retryTransientFailures(ctx, entries, resp, metrics, attempts); err != nil {
+		return err
 	}
 
+	// deadLettered tracks which failures were successfully published to
+	// DeadLetterTopic, so worstResponseError doesn't surface them as the
+	// batch's representative error.
+	deadLettered := make([]bool, len(dms))
 	for n, dm := range dms {
 		respEntity := resp.Responses[n]
-		if respEntity.Success && dm.AfterSend != nil {
-			asFunc := func(i interface{}) bool {
-				if p, ok := i.(**messaging.SendResponse); ok {
-					*p = resp.Responses[n]
-					return true
-				}
-				return false
-			}
-			if err := dm.AfterSend(asFunc); err != nil {
-				return err
-			}
-		}
-		if respEntity.Error != nil {
-			t.opts.Logger.Error(
-				"Error from response entity",
-				zap.String("from", "pubsub.firebase.sendBatch.resposneEntity"),
-				zap.Error(respEntity.Error),
-				zap.Any("requestEntity", entries[n]),
-				zap.Any("responseEntity", respEntity))
-		}
-	}
+		metrics.ObserveMessageOutcome(messageOutcomeClass(respEntity.Error))
 
-	if resp.SuccessCount == len(dms) {
-		for n, dm := range dms {
+		// Exactly one terminal callback fires per message: AfterSend on
+		// success, OnError otherwise.
+		if respEntity.Error == nil {
 			if dm.AfterSend != nil {
 				asFunc := func(i interface{}) bool {
 					if p, ok := i.(**messaging.SendResponse); ok {
@@ -135,14 +402,319 @@ func (t *fcmTopic) SendBatch(ctx context.Context, dms []*driver.Message) error {
 					return err
 				}
 			}
+			continue
+		}
+
+		t.opts.Logger.Error(
+			"Error from response entity",
+			zap.String("from", "pubsub.firebase.sendBatch.resposneEntity"),
+			zap.Error(respEntity.Error),
+			zap.Any("requestEntity", entries[n]),
+			zap.Any("responseEntity", respEntity))
+
+		if t.opts.DeadLetterTopic != nil && t.isTerminalError(respEntity.Error, attempts[n]) {
+			if dlqErr := t.sendToDeadLetter(ctx, dm, entries[n], respEntity, attempts[n]); dlqErr != nil {
+				t.opts.Logger.Error(
+					"Failed to publish to dead-letter topic",
+					zap.String("from", "pubsub.firebase.sendBatch.deadLetter"),
+					zap.Error(dlqErr),
+					zap.Any("requestEntity", entries[n]))
+			} else {
+				deadLettered[n] = true
+			}
+		}
+
+		if t.opts.OnError != nil {
+			t.opts.OnError(respEntity, respEntity.Error)
+		}
+	}
+
+	if resp.FailureCount > 0 {
+		if err := worstResponseError(resp, deadLettered); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// invalidCloudEventError marks a per-message SendBatch failure caused by a
+// malformed CloudEvents envelope (TopicOptions.Encoding ==
+// EncodingCloudEvent) rather than an error reported by FCM itself, so
+// ErrorCode/IsRetryable/isTerminalError still classify it as
+// gcerrors.InvalidArgument and terminal.
+type invalidCloudEventError struct {
+	err error
+}
+
+func (e *invalidCloudEventError) Error() string {
+	return fmt.Sprintf("invalid cloudevents envelope: %v", e.err)
+}
+
+func (e *invalidCloudEventError) Unwrap() error {
+	return e.err
+}
+
+// decodeMessage decodes a driver.Message.Body into a messaging.Message per
+// TopicOptions.Encoding.
+func (t *fcmTopic) decodeMessage(body []byte) (*messaging.Message, error) {
+	if t.opts.Encoding == EncodingCloudEvent {
+		return decodeCloudEventMessage(body)
+	}
+	entry := &messaging.Message{}
+	if err := entry.UnmarshalJSON(body); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// decodeCloudEventMessage parses body as a structured CloudEvents JSON
+// envelope, unmarshals its data as a messaging.Message, and stamps selected
+// CloudEvents attributes into the message's Data so downstream clients can
+// correlate the notification back to the originating event.
+func decodeCloudEventMessage(body []byte) (*messaging.Message, error) {
+	var ce cloudevents.Event
+	if err := ce.UnmarshalJSON(body); err != nil {
+		return nil, &invalidCloudEventError{err: fmt.Errorf("parse cloudevents envelope: %w", err)}
+	}
+	entry := &messaging.Message{}
+	if err := json.Unmarshal(ce.Data(), entry); err != nil {
+		return nil, &invalidCloudEventError{err: fmt.Errorf("parse cloudevents data as messaging.Message: %w", err)}
+	}
+	if entry.Data == nil {
+		entry.Data = map[string]string{}
+	}
+	entry.Data["ce-id"] = ce.ID()
+	entry.Data["ce-source"] = ce.Source()
+	entry.Data["ce-type"] = ce.Type()
+	if !ce.Time().IsZero() {
+		entry.Data["ce-time"] = ce.Time().Format(time.RFC3339Nano)
+	}
+	if subject := ce.Subject(); subject != "" {
+		entry.Data["ce-subject"] = subject
+	}
+	return entry, nil
+}
+
+// NewCloudEventMessage marshals ce as a structured CloudEvents JSON envelope
+// carrying target as its data, matching what SendBatch expects when
+// TopicOptions.Encoding is EncodingCloudEvent. Producers can publish the
+// result directly as a driver.Message.Body instead of hand-marshaling the
+// envelope.
+func NewCloudEventMessage(ce cloudevents.Event, target messaging.Message) ([]byte, error) {
+	if err := ce.SetData(cloudevents.ApplicationJSON, target); err != nil {
+		return nil, err
+	}
+	return ce.MarshalJSON()
+}
+
+// sendAll calls the Firebase Admin SDK, honoring TopicOptions.DryRun.
+func (t *fcmTopic) sendAll(ctx context.Context, entries []*messaging.Message) (*messaging.BatchResponse, error) {
+	if t.opts.DryRun {
+		return t.client.SendAllDryRun(ctx, entries)
+	}
+	return t.client.SendAll(ctx, entries)
+}
+
+// retryTransientFailures retries the subset of resp whose error is
+// retryable, merging successes back into resp in place so each driver.Message
+// in the batch still maps 1:1 to resp.Responses by index. It honors
+// ctx.Done() between attempts and gives up, leaving the remaining failures
+// in resp, once the retry policy's attempt budget is exhausted. attempts
+// tracks how many times each index has been sent to FCM (including the
+// initial SendBatch attempt), so callers downstream of a retry (dead-letter
+// classification, DeadLetterEnvelope) can see the real attempt count rather
+// than assuming one.
+func (t *fcmTopic) retryTransientFailures(ctx context.Context, entries []*messaging.Message, resp *messaging.BatchResponse, metrics Metrics, attempts []int) error {
+	policy := t.retryPolicy()
+	for attempt := 1; attempt < policy.MaxAttempts; attempt++ {
+		var retryIdx []int
+		for n, r := range resp.Responses {
+			if r.Error != nil && t.IsRetryable(r.Error) {
+				retryIdx = append(retryIdx, n)
+			}
+		}
+		if len(retryIdx) == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(policy.backoff(attempt)):
+		}
+
+		retryEntries := make([]*messaging.Message, len(retryIdx))
+		for i, idx := range retryIdx {
+			retryEntries[i] = entries[idx]
+			attempts[idx]++
+			metrics.ObserveRetryAttempt(messageOutcomeClass(resp.Responses[idx].Error))
+		}
+
+		retryResp, err := t.sendAll(ctx, retryEntries)
+		if err != nil {
+			t.opts.Logger.Error(
+				"Error retrying SendBatch",
+				zap.String("from", "pubsub.firebase.sendBatch.retry"),
+				zap.Int("attempt", attempt),
+				zap.Error(err))
+			break
+		}
+		for i, idx := range retryIdx {
+			resp.Responses[idx] = retryResp.Responses[i]
+		}
+	}
+
+	recomputeBatchCounts(resp)
+	for _, r := range resp.Responses {
+		if r.Error != nil && t.IsRetryable(r.Error) {
+			t.opts.Logger.Error(
+				"Retry budget exhausted for message",
+				zap.String("from", "pubsub.firebase.sendBatch.retry"),
+				zap.Int("maxAttempts", policy.MaxAttempts),
+				zap.Error(r.Error))
 		}
 	}
 	return nil
 }
 
-func (t *fcmTopic) IsRetryable(error) bool {
-	// The client handles retries.
-	return false
+// recomputeBatchCounts refreshes resp.SuccessCount/FailureCount after
+// in-place merges of retried responses.
+func recomputeBatchCounts(resp *messaging.BatchResponse) {
+	success := 0
+	for _, r := range resp.Responses {
+		if r.Success {
+			success++
+		}
+	}
+	resp.SuccessCount = success
+	resp.FailureCount = len(resp.Responses) - success
+}
+
+// retryPolicy resolves the effective RetryPolicy, falling back to
+// defaultRetryPolicy when TopicOptions.RetryPolicy is unset.
+func (t *fcmTopic) retryPolicy() RetryPolicy {
+	if t.opts.RetryPolicy != nil {
+		return *t.opts.RetryPolicy
+	}
+	return defaultRetryPolicy
+}
+
+// worstResponseError picks the most severe per-message failure out of a
+// batch response so that SendBatch can surface a single representative
+// error (and therefore a single retryability decision) to gocloud's
+// portable pubsub.Topic. Failures that were already handled by publishing
+// to the dead-letter topic are excluded, since the rest of the batch
+// should still be reported as a success.
+func worstResponseError(resp *messaging.BatchResponse, handled []bool) error {
+	var worst error
+	worstRank := -1
+	for n, r := range resp.Responses {
+		if r.Error == nil || handled[n] {
+			continue
+		}
+		if rank := errorSeverity(r.Error); rank > worstRank {
+			worst = r.Error
+			worstRank = rank
+		}
+	}
+	return worst
+}
+
+// DeadLetterEnvelope is the structured payload published to
+// TopicOptions.DeadLetterTopic when an FCM send permanently fails. It
+// carries the original request and failure context so operators (or an
+// automated token-cleanup/unsubscribe flow) can act on it without
+// re-deriving it from logs.
+type DeadLetterEnvelope struct {
+	Body         []byte            `json:"body"`
+	Metadata     map[string]string `json:"metadata,omitempty"`
+	Token        string            `json:"token,omitempty"`
+	FCMError     string            `json:"fcm_error"`
+	ErrorCode    string            `json:"error_code"`
+	AttemptCount int               `json:"attempt_count"`
+}
+
+// isTerminalError reports whether err should be dead-lettered instead of
+// surfaced as a retryable batch failure. attemptCount is how many times the
+// message has been sent to FCM so far (including the initial attempt). If
+// TopicOptions.MaxDeliveryAttempts is set and attemptCount has reached it,
+// the failure is terminal regardless of TerminalErrorClassifier or error
+// class, since there's no budget left to retry it further.
+func (t *fcmTopic) isTerminalError(err error, attemptCount int) bool {
+	if t.opts.MaxDeliveryAttempts > 0 && attemptCount >= t.opts.MaxDeliveryAttempts {
+		return true
+	}
+	if t.opts.TerminalErrorClassifier != nil {
+		return t.opts.TerminalErrorClassifier(err)
+	}
+	var iceErr *invalidCloudEventError
+	if errors.As(err, &iceErr) {
+		return true
+	}
+	return messaging.IsUnregistered(err) || messaging.IsInvalidArgument(err) || messaging.IsSenderIDMismatch(err)
+}
+
+// sendToDeadLetter publishes a DeadLetterEnvelope for a single failed
+// message to TopicOptions.DeadLetterTopic.
+func (t *fcmTopic) sendToDeadLetter(ctx context.Context, dm *driver.Message, entry *messaging.Message, respEntity *messaging.SendResponse, attemptCount int) error {
+	envelope := &DeadLetterEnvelope{
+		Body:         dm.Body,
+		Metadata:     dm.Metadata,
+		FCMError:     respEntity.Error.Error(),
+		ErrorCode:    t.ErrorCode(respEntity.Error).String(),
+		AttemptCount: attemptCount,
+	}
+	// entry is nil when the message never made it to FCM (e.g. a malformed
+	// CloudEvents envelope), so there's no token to carry forward.
+	if entry != nil {
+		envelope.Token = entry.Token
+	}
+	body, err := json.Marshal(envelope)
+	if err != nil {
+		return err
+	}
+	return t.opts.DeadLetterTopic.Send(ctx, &pubsub.Message{Body: body})
+}
+
+// errorSeverity orders FCM error classes from least to most severe, most
+// severe meaning "most worth surfacing to the caller and retrying".
+func errorSeverity(err error) int {
+	var iceErr *invalidCloudEventError
+	if errors.As(err, &iceErr) {
+		return 2
+	}
+	switch {
+	case messaging.IsInternal(err):
+		return 6
+	case messaging.IsUnavailable(err):
+		return 5
+	case messaging.IsQuotaExceeded(err):
+		return 4
+	case messaging.IsSenderIDMismatch(err), messaging.IsThirdPartyAuthError(err):
+		return 3
+	case messaging.IsInvalidArgument(err):
+		return 2
+	case messaging.IsUnregistered(err):
+		return 1
+	default:
+		return 0
+	}
+}
+
+// isRetryableErrorCode decides whether an ErrorCode warrants a retry. It's a
+// package-level var, rather than inlined in IsRetryable, so tests can swap in
+// a fake classifier without needing to construct real FCM error values.
+var isRetryableErrorCode = func(code gcerrors.ErrorCode) bool {
+	switch code {
+	case gcerrors.Unavailable, gcerrors.Internal, gcerrors.ResourceExhausted:
+		return true
+	default:
+		return false
+	}
+}
+
+func (t *fcmTopic) IsRetryable(err error) bool {
+	return isRetryableErrorCode(t.ErrorCode(err))
 }
 
 func (t *fcmTopic) As(i interface{}) bool {
@@ -150,7 +722,11 @@ func (t *fcmTopic) As(i interface{}) bool {
 	if !ok {
 		return false
 	}
-	*c = t.client
+	client, ok := t.client.(*messaging.Client)
+	if !ok {
+		return false
+	}
+	*c = client
 	return true
 }
 
@@ -159,13 +735,31 @@ func (t *fcmTopic) ErrorAs(err error, i interface{}) bool {
 }
 
 func (t *fcmTopic) ErrorCode(err error) gcerrors.ErrorCode {
-	if err != nil {
+	if err == nil {
 		return gcerrors.OK
 	}
-	// TODO (taekyeom) sophisticated error code
-	return gcerrors.Unknown
+	var iceErr *invalidCloudEventError
+	if errors.As(err, &iceErr) {
+		return gcerrors.InvalidArgument
+	}
+	switch {
+	case messaging.IsUnregistered(err):
+		return gcerrors.NotFound
+	case messaging.IsInvalidArgument(err):
+		return gcerrors.InvalidArgument
+	case messaging.IsSenderIDMismatch(err), messaging.IsThirdPartyAuthError(err):
+		return gcerrors.PermissionDenied
+	case messaging.IsQuotaExceeded(err):
+		return gcerrors.ResourceExhausted
+	case messaging.IsUnavailable(err):
+		return gcerrors.Unavailable
+	case messaging.IsInternal(err):
+		return gcerrors.Internal
+	default:
+		return gcerrors.Unknown
+	}
 }
 
 func (t *fcmTopic) Close() error {
 	return nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,160 @@
+package firebase
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"firebase.google.com/go/v4/messaging"
+	"gocloud.dev/pubsub/driver"
+)
+
+// messageCallbackCounts tracks, per driver.Message, how many times
+// AfterSend and OnError fired, so tests can assert the exactly-once
+// contract: AfterSend xor OnError per message, never both, never neither.
+type messageCallbackCounts struct {
+	afterSend map[int]int
+	onError   map[int]int
+}
+
+func newMessageCallbackCounts() *messageCallbackCounts {
+	return &messageCallbackCounts{afterSend: map[int]int{}, onError: map[int]int{}}
+}
+
+func (c *messageCallbackCounts) assertExactlyOnce(t *testing.T, n int) {
+	t.Helper()
+	as, oe := c.afterSend[n], c.onError[n]
+	if as+oe != 1 {
+		t.Errorf("message %d: AfterSend fired %d times, OnError fired %d times; want exactly one of them to fire once", n, as, oe)
+	}
+}
+
+func TestSendBatch_AfterSendOnError_FullSuccess(t *testing.T) {
+	ctx := context.Background()
+	sender := &fakeSender{
+		responses: []*messaging.BatchResponse{{
+			Responses: []*messaging.SendResponse{
+				{Success: true, MessageID: "m0"},
+				{Success: true, MessageID: "m1"},
+			},
+		}},
+	}
+	counts := newMessageCallbackCounts()
+	opts := &TopicOptions{
+		Logger: newTestLogger(),
+		OnError: func(*messaging.SendResponse, error) {
+			t.Error("OnError should not fire for a fully successful batch")
+		},
+	}
+	topic := &fcmTopic{client: sender, opts: opts}
+
+	dms := make([]*driver.Message, 2)
+	for n := range dms {
+		n := n
+		dms[n] = &driver.Message{
+			Body: messageBody("tok"),
+			AfterSend: func(func(interface{}) bool) error {
+				counts.afterSend[n]++
+				return nil
+			},
+		}
+	}
+
+	if err := topic.SendBatch(ctx, dms); err != nil {
+		t.Fatalf("SendBatch: %v", err)
+	}
+	for n := range dms {
+		counts.assertExactlyOnce(t, n)
+	}
+}
+
+func TestSendBatch_AfterSendOnError_FullFailure(t *testing.T) {
+	ctx := context.Background()
+	failErr := errors.New("send failed")
+	sender := &fakeSender{
+		responses: []*messaging.BatchResponse{{
+			Responses: []*messaging.SendResponse{
+				{Success: false, Error: failErr},
+				{Success: false, Error: failErr},
+			},
+		}},
+	}
+	var onErrorCalls int
+	opts := &TopicOptions{
+		Logger: newTestLogger(),
+		OnError: func(*messaging.SendResponse, error) {
+			onErrorCalls++
+		},
+	}
+	topic := &fcmTopic{client: sender, opts: opts}
+
+	dms := make([]*driver.Message, 2)
+	for n := range dms {
+		n := n
+		dms[n] = &driver.Message{
+			Body: messageBody("tok"),
+			AfterSend: func(func(interface{}) bool) error {
+				t.Errorf("AfterSend should not fire for failed message %d", n)
+				return nil
+			},
+		}
+	}
+
+	err := topic.SendBatch(ctx, dms)
+	if !errors.Is(err, failErr) {
+		t.Fatalf("expected SendBatch to surface the failure, got %v", err)
+	}
+	if onErrorCalls != 2 {
+		t.Fatalf("expected OnError to fire once per failed message (2 total), got %d", onErrorCalls)
+	}
+}
+
+func TestSendBatch_AfterSendOnError_Mixed(t *testing.T) {
+	ctx := context.Background()
+	failErr := errors.New("send failed")
+	sender := &fakeSender{
+		responses: []*messaging.BatchResponse{{
+			Responses: []*messaging.SendResponse{
+				{Success: true, MessageID: "m0"},
+				{Success: false, Error: failErr},
+				{Success: true, MessageID: "m2"},
+			},
+		}},
+	}
+
+	var onErrorCalls int
+	opts := &TopicOptions{
+		Logger: newTestLogger(),
+		OnError: func(*messaging.SendResponse, error) {
+			onErrorCalls++
+		},
+	}
+	topic := &fcmTopic{client: sender, opts: opts}
+
+	counts := newMessageCallbackCounts()
+	dms := make([]*driver.Message, 3)
+	for n := range dms {
+		n := n
+		dms[n] = &driver.Message{
+			Body: messageBody("tok"),
+			AfterSend: func(func(interface{}) bool) error {
+				counts.afterSend[n]++
+				return nil
+			},
+		}
+	}
+
+	err := topic.SendBatch(ctx, dms)
+	if !errors.Is(err, failErr) {
+		t.Fatalf("expected SendBatch to surface the one failure, got %v", err)
+	}
+	if onErrorCalls != 1 {
+		t.Fatalf("expected OnError to fire once for the one failed message, got %d", onErrorCalls)
+	}
+	if counts.afterSend[0] != 1 || counts.afterSend[2] != 1 {
+		t.Fatalf("expected AfterSend to fire once each for the two successful messages, got %v", counts.afterSend)
+	}
+	if _, fired := counts.afterSend[1]; fired {
+		t.Fatal("AfterSend should not have fired for the failed message")
+	}
+}
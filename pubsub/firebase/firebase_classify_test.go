@@ -0,0 +1,93 @@
+package firebase
+
+import (
+	"errors"
+	"testing"
+
+	"gocloud.dev/gcerrors"
+)
+
+// TestErrorCode_IsRetryable is a table test over fcmTopic.ErrorCode and
+// fcmTopic.IsRetryable's behavior with the default isRetryableErrorCode.
+//
+// The messaging.Is* predicates (IsUnregistered, IsInvalidArgument,
+// IsSenderIDMismatch, IsQuotaExceeded, IsUnavailable, IsInternal,
+// IsThirdPartyAuthError) that ErrorCode's switch keys off match against an
+// unexported error type from firebase.google.com/go/v4/internal, which Go's
+// compiler refuses to import from outside the firebase.google.com/go/v4
+// module tree. The Admin SDK exposes no constructor for that type either, so
+// there is no way from this module to synthesize a value that actually
+// satisfies those predicates and exercise the classified branches of the
+// switch directly. The cases below cover every branch this module CAN drive:
+// nil, our own invalidCloudEventError (which ErrorCode/isTerminalError special-
+// case ahead of the messaging.Is* switch), and an arbitrary error that none
+// of the messaging.Is* predicates recognize, which falls through to Unknown.
+func TestErrorCode_IsRetryable(t *testing.T) {
+	topic := &fcmTopic{opts: &TopicOptions{}}
+	genericErr := errors.New("some non-FCM error")
+	ceErr := &invalidCloudEventError{err: genericErr}
+
+	tests := []struct {
+		name          string
+		err           error
+		wantCode      gcerrors.ErrorCode
+		wantRetryable bool
+	}{
+		{
+			name:          "nil error is OK and not retryable",
+			err:           nil,
+			wantCode:      gcerrors.OK,
+			wantRetryable: false,
+		},
+		{
+			name:          "malformed CloudEvents envelope is InvalidArgument and not retryable",
+			err:           ceErr,
+			wantCode:      gcerrors.InvalidArgument,
+			wantRetryable: false,
+		},
+		{
+			name:          "an error none of the messaging.Is* predicates recognize falls through to Unknown and is not retryable",
+			err:           genericErr,
+			wantCode:      gcerrors.Unknown,
+			wantRetryable: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := topic.ErrorCode(tt.err); got != tt.wantCode {
+				t.Errorf("ErrorCode() = %v, want %v", got, tt.wantCode)
+			}
+			if got := topic.IsRetryable(tt.err); got != tt.wantRetryable {
+				t.Errorf("IsRetryable() = %v, want %v", got, tt.wantRetryable)
+			}
+		})
+	}
+}
+
+// TestIsRetryableErrorCode_DefaultClassification is a direct table test of
+// the default isRetryableErrorCode classifier (the one IsRetryable consults
+// via ErrorCode), independent of how a gcerrors.ErrorCode was produced. This
+// covers the code->retryable mapping itself, complementing
+// TestErrorCode_IsRetryable above which covers error->code.
+func TestIsRetryableErrorCode_DefaultClassification(t *testing.T) {
+	tests := []struct {
+		code gcerrors.ErrorCode
+		want bool
+	}{
+		{gcerrors.OK, false},
+		{gcerrors.Unknown, false},
+		{gcerrors.NotFound, false},
+		{gcerrors.InvalidArgument, false},
+		{gcerrors.PermissionDenied, false},
+		{gcerrors.ResourceExhausted, true},
+		{gcerrors.Unavailable, true},
+		{gcerrors.Internal, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.code.String(), func(t *testing.T) {
+			if got := isRetryableErrorCode(tt.code); got != tt.want {
+				t.Errorf("isRetryableErrorCode(%v) = %v, want %v", tt.code, got, tt.want)
+			}
+		})
+	}
+}
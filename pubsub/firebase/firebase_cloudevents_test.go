@@ -0,0 +1,115 @@
+package firebase
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"firebase.google.com/go/v4/messaging"
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"gocloud.dev/pubsub/driver"
+	"gocloud.dev/pubsub/mempubsub"
+)
+
+// TestCloudEventRoundTrip asserts that a messaging.Message produced by
+// NewCloudEventMessage survives the trip through decodeCloudEventMessage
+// with its fields and CloudEvents attributes intact.
+func TestCloudEventRoundTrip(t *testing.T) {
+	ce := cloudevents.NewEvent()
+	ce.SetID("event-1")
+	ce.SetSource("test/source")
+	ce.SetType("test.event.sent")
+	ce.SetSubject("user-42")
+	ce.SetTime(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC))
+
+	target := messaging.Message{
+		Token: "tok-1",
+		Data:  map[string]string{"foo": "bar"},
+	}
+
+	body, err := NewCloudEventMessage(ce, target)
+	if err != nil {
+		t.Fatalf("NewCloudEventMessage: %v", err)
+	}
+
+	entry, err := decodeCloudEventMessage(body)
+	if err != nil {
+		t.Fatalf("decodeCloudEventMessage: %v", err)
+	}
+
+	if entry.Token != target.Token {
+		t.Errorf("entry.Token = %q, want %q", entry.Token, target.Token)
+	}
+	if entry.Data["foo"] != "bar" {
+		t.Errorf("entry.Data[foo] = %q, want %q", entry.Data["foo"], "bar")
+	}
+	wantAttrs := map[string]string{
+		"ce-id":      "event-1",
+		"ce-source":  "test/source",
+		"ce-type":    "test.event.sent",
+		"ce-subject": "user-42",
+	}
+	for k, want := range wantAttrs {
+		if got := entry.Data[k]; got != want {
+			t.Errorf("entry.Data[%s] = %q, want %q", k, got, want)
+		}
+	}
+	if entry.Data["ce-time"] == "" {
+		t.Error("entry.Data[ce-time] is empty, want the stamped event time")
+	}
+}
+
+func TestDecodeCloudEventMessage_Malformed(t *testing.T) {
+	_, err := decodeCloudEventMessage([]byte("not a cloudevent"))
+	if err == nil {
+		t.Fatal("expected an error decoding a malformed CloudEvents envelope")
+	}
+	if !isInvalidCloudEventError(err) {
+		t.Fatalf("expected a malformed envelope to classify as invalidCloudEventError, got %T: %v", err, err)
+	}
+}
+
+func isInvalidCloudEventError(err error) bool {
+	_, ok := err.(*invalidCloudEventError)
+	return ok
+}
+
+// TestSendBatch_MalformedCloudEvent_DeadLettersWithoutPanic is a regression
+// test for a malformed CloudEvents envelope going through SendBatch with a
+// DeadLetterTopic configured: decodeMessage leaves the entry nil for that
+// message, and isTerminalError classifies invalidCloudEventError as
+// terminal, so sendToDeadLetter must tolerate a nil entry instead of
+// dereferencing it for the token.
+func TestSendBatch_MalformedCloudEvent_DeadLettersWithoutPanic(t *testing.T) {
+	ctx := context.Background()
+	dlqTopic := mempubsub.NewTopic()
+	defer dlqTopic.Shutdown(ctx)
+	dlqSub := mempubsub.NewSubscription(dlqTopic, time.Minute)
+	defer dlqSub.Shutdown(ctx)
+
+	sender := &fakeSender{}
+	opts := &TopicOptions{
+		Logger:          newTestLogger(),
+		Encoding:        EncodingCloudEvent,
+		DeadLetterTopic: dlqTopic,
+	}
+	topic := &fcmTopic{client: sender, opts: opts}
+
+	dms := []*driver.Message{{Body: []byte("not a cloudevent")}}
+
+	// The point of this test is that this call doesn't panic. Once the
+	// malformed envelope is dead-lettered it no longer fails the batch.
+	err := topic.SendBatch(ctx, dms)
+	if err != nil {
+		t.Fatalf("expected the dead-lettered malformed envelope not to fail the batch, got %v", err)
+	}
+
+	msg, err := dlqSub.Receive(ctx)
+	if err != nil {
+		t.Fatalf("expected the malformed envelope to be dead-lettered, got error receiving: %v", err)
+	}
+	msg.Ack()
+	if len(msg.Body) == 0 {
+		t.Error("expected a non-empty DeadLetterEnvelope body")
+	}
+}
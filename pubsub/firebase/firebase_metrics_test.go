@@ -0,0 +1,155 @@
+package firebase
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"firebase.google.com/go/v4/messaging"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"gocloud.dev/pubsub/driver"
+)
+
+// TestNewPrometheusMetrics_RegistersCollectors verifies that
+// NewPrometheusMetrics registers its four collectors against the given
+// Registerer without error, and that registering a second instance against
+// the same Registerer fails (proof the collectors were actually registered,
+// not silently dropped).
+func TestNewPrometheusMetrics_RegistersCollectors(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	if _, err := NewPrometheusMetrics(reg); err != nil {
+		t.Fatalf("NewPrometheusMetrics: %v", err)
+	}
+	if _, err := NewPrometheusMetrics(reg); err == nil {
+		t.Fatal("expected a second NewPrometheusMetrics against the same Registerer to fail with an AlreadyRegisteredError")
+	}
+}
+
+// TestSendBatch_PrometheusMetrics_MessageOutcomes verifies that SendBatch
+// drives ObserveMessageOutcome with the right class labels for a mixed
+// batch of successes and classified failures.
+func TestSendBatch_PrometheusMetrics_MessageOutcomes(t *testing.T) {
+	ctx := context.Background()
+	nonTerminalErr := errors.New("transient backend hiccup")
+
+	sender := &fakeSender{
+		responses: []*messaging.BatchResponse{
+			{
+				Responses: []*messaging.SendResponse{
+					{Success: true, MessageID: "msg-ok"},
+					{Success: false, Error: nonTerminalErr},
+				},
+			},
+		},
+	}
+
+	reg := prometheus.NewRegistry()
+	metrics, err := NewPrometheusMetrics(reg)
+	if err != nil {
+		t.Fatalf("NewPrometheusMetrics: %v", err)
+	}
+	opts := &TopicOptions{Logger: newTestLogger(), Metrics: metrics}
+	topic := &fcmTopic{client: sender, opts: opts}
+
+	dms := []*driver.Message{
+		{Body: messageBody("tok-ok")},
+		{Body: messageBody("tok-bad")},
+	}
+	if err := topic.SendBatch(ctx, dms); err == nil {
+		t.Fatal("expected SendBatch to surface the failure")
+	}
+
+	if got := testutil.ToFloat64(metrics.outcomes.WithLabelValues("ok")); got != 1 {
+		t.Errorf("outcomes[ok] = %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(metrics.outcomes.WithLabelValues("other")); got != 1 {
+		t.Errorf("outcomes[other] = %v, want 1 (nonTerminalErr doesn't match any FCM predicate)", got)
+	}
+}
+
+// TestSendBatch_PrometheusMetrics_RetryAttempt verifies that a retried
+// message's class is observed via ObserveRetryAttempt.
+func TestSendBatch_PrometheusMetrics_RetryAttempt(t *testing.T) {
+	withAllErrorsRetryable(t)
+	ctx := context.Background()
+	retryableErr := errors.New("temporarily unavailable")
+
+	sender := &fakeSender{
+		responses: []*messaging.BatchResponse{
+			{Responses: []*messaging.SendResponse{{Success: false, Error: retryableErr}}},
+			{Responses: []*messaging.SendResponse{{Success: true, MessageID: "m0-retry"}}},
+		},
+	}
+
+	reg := prometheus.NewRegistry()
+	metrics, err := NewPrometheusMetrics(reg)
+	if err != nil {
+		t.Fatalf("NewPrometheusMetrics: %v", err)
+	}
+	opts := &TopicOptions{Logger: newTestLogger(), Metrics: metrics, RetryPolicy: fastRetryPolicy()}
+	topic := &fcmTopic{client: sender, opts: opts}
+
+	if err := topic.SendBatch(ctx, []*driver.Message{{Body: messageBody("tok-0")}}); err != nil {
+		t.Fatalf("SendBatch: %v", err)
+	}
+
+	if got := testutil.ToFloat64(metrics.retryAttempts.WithLabelValues("other")); got != 1 {
+		t.Errorf("retryAttempts[other] = %v, want 1", got)
+	}
+}
+
+// TestSendBatch_LegacyMetricsAdapter_CountPerFailedMessage verifies that the
+// legacy MetricService path issues one Count(..., 1, ...) call per failed
+// message rather than a single call carrying the batch's FailureCount.
+func TestSendBatch_LegacyMetricsAdapter_CountPerFailedMessage(t *testing.T) {
+	ctx := context.Background()
+	failErr := errors.New("send failed")
+
+	sender := &fakeSender{
+		responses: []*messaging.BatchResponse{
+			{
+				Responses: []*messaging.SendResponse{
+					{Success: true, MessageID: "msg-ok"},
+					{Success: false, Error: failErr},
+					{Success: false, Error: failErr},
+				},
+			},
+		},
+	}
+
+	spy := &legacyCountSpy{}
+	opts := &TopicOptions{Logger: newTestLogger(), MetricService: spy}
+	topic := &fcmTopic{client: sender, opts: opts}
+
+	dms := []*driver.Message{
+		{Body: messageBody("tok-ok")},
+		{Body: messageBody("tok-bad-0")},
+		{Body: messageBody("tok-bad-1")},
+	}
+	if err := topic.SendBatch(ctx, dms); err == nil {
+		t.Fatal("expected SendBatch to surface a failure")
+	}
+
+	if len(spy.counts) != 2 {
+		t.Fatalf("expected one Count call per failed message (2 total), got %d: %v", len(spy.counts), spy.counts)
+	}
+	for _, c := range spy.counts {
+		if c != 1 {
+			t.Errorf("Count call carried count=%d, want 1", c)
+		}
+	}
+}
+
+// legacyCountSpy implements countMetricInterface, recording only the count
+// argument of each Count call.
+type legacyCountSpy struct {
+	counts []int64
+}
+
+func (s *legacyCountSpy) Timing(stat string, duration time.Duration, tagKvs ...string) {}
+
+func (s *legacyCountSpy) Count(stat string, count int64, tagKvs ...string) {
+	s.counts = append(s.counts, count)
+}
@@ -0,0 +1,140 @@
+package firebase
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"firebase.google.com/go/v4/messaging"
+	"gocloud.dev/gcerrors"
+	"gocloud.dev/pubsub/driver"
+)
+
+// withAllErrorsRetryable makes every ErrorCode retryable for the duration
+// of a test, so retry tests can use plain errors instead of needing to
+// construct FCM-specific error values that classify as Unavailable/
+// Internal/ResourceExhausted.
+func withAllErrorsRetryable(t *testing.T) {
+	t.Helper()
+	orig := isRetryableErrorCode
+	isRetryableErrorCode = func(gcerrors.ErrorCode) bool { return true }
+	t.Cleanup(func() { isRetryableErrorCode = orig })
+}
+
+func fastRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     time.Millisecond,
+		Multiplier:     1,
+	}
+}
+
+// TestSendBatch_RetryMergeByIndex verifies that a retried subset of a batch
+// is merged back into the right positions, not just appended/reordered, and
+// that the retry call carries only the failed message.
+func TestSendBatch_RetryMergeByIndex(t *testing.T) {
+	withAllErrorsRetryable(t)
+	ctx := context.Background()
+	retryableErr := errors.New("temporarily unavailable")
+
+	sender := &fakeSender{
+		responses: []*messaging.BatchResponse{
+			{
+				Responses: []*messaging.SendResponse{
+					{Success: true, MessageID: "m0"},
+					{Success: false, Error: retryableErr},
+					{Success: true, MessageID: "m2"},
+				},
+			},
+			{
+				// Only index 1 is retried, so this response has one entry.
+				Responses: []*messaging.SendResponse{
+					{Success: true, MessageID: "m1-retry"},
+				},
+			},
+		},
+	}
+
+	opts := &TopicOptions{Logger: newTestLogger(), RetryPolicy: fastRetryPolicy()}
+	topic := &fcmTopic{client: sender, opts: opts}
+
+	dms := []*driver.Message{
+		{Body: messageBody("tok-0")},
+		{Body: messageBody("tok-1")},
+		{Body: messageBody("tok-2")},
+	}
+
+	if err := topic.SendBatch(ctx, dms); err != nil {
+		t.Fatalf("SendBatch: %v", err)
+	}
+
+	if len(sender.calls) != 2 {
+		t.Fatalf("expected 2 calls to the sender (initial + 1 retry), got %d", len(sender.calls))
+	}
+	if got := len(sender.calls[1]); got != 1 {
+		t.Fatalf("expected the retry call to carry only the 1 retryable message, got %d", got)
+	}
+	if got := sender.calls[1][0].Token; got != "tok-1" {
+		t.Fatalf("retry call carried the wrong message: token = %q, want %q", got, "tok-1")
+	}
+}
+
+// TestSendBatch_DryRunRetryParity verifies that retries use the same
+// DryRun/non-DryRun send path as the initial send.
+func TestSendBatch_DryRunRetryParity(t *testing.T) {
+	withAllErrorsRetryable(t)
+	ctx := context.Background()
+	retryableErr := errors.New("temporarily unavailable")
+
+	sender := &fakeSender{
+		responses: []*messaging.BatchResponse{
+			{Responses: []*messaging.SendResponse{{Success: false, Error: retryableErr}}},
+			{Responses: []*messaging.SendResponse{{Success: true, MessageID: "m0-retry"}}},
+		},
+	}
+
+	opts := &TopicOptions{DryRun: true, Logger: newTestLogger(), RetryPolicy: fastRetryPolicy()}
+	topic := &fcmTopic{client: sender, opts: opts}
+
+	if err := topic.SendBatch(ctx, []*driver.Message{{Body: messageBody("tok-0")}}); err != nil {
+		t.Fatalf("SendBatch: %v", err)
+	}
+
+	if sender.dryRunCalls != 2 {
+		t.Fatalf("expected both the initial send and the retry to use SendAllDryRun, got %d dry-run calls", sender.dryRunCalls)
+	}
+}
+
+// TestSendBatch_RetryBudgetExhausted verifies that a message still failing
+// after the retry budget is exhausted surfaces as the batch's error rather
+// than hanging or being dropped.
+func TestSendBatch_RetryBudgetExhausted(t *testing.T) {
+	withAllErrorsRetryable(t)
+	ctx := context.Background()
+	retryableErr := errors.New("still unavailable")
+
+	sender := &fakeSender{
+		responses: []*messaging.BatchResponse{
+			{Responses: []*messaging.SendResponse{{Success: false, Error: retryableErr}}},
+			{Responses: []*messaging.SendResponse{{Success: false, Error: retryableErr}}},
+		},
+	}
+
+	opts := &TopicOptions{Logger: newTestLogger(), RetryPolicy: &RetryPolicy{
+		MaxAttempts:    2,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     time.Millisecond,
+		Multiplier:     1,
+	}}
+	topic := &fcmTopic{client: sender, opts: opts}
+
+	err := topic.SendBatch(ctx, []*driver.Message{{Body: messageBody("tok-0")}})
+	if !errors.Is(err, retryableErr) {
+		t.Fatalf("expected the exhausted retry's error to surface, got %v", err)
+	}
+	if len(sender.calls) != 2 {
+		t.Fatalf("expected exactly 1 retry (MaxAttempts=2), got %d total calls", len(sender.calls))
+	}
+}
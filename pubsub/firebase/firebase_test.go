@@ -0,0 +1,204 @@
+package firebase
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"firebase.google.com/go/v4/messaging"
+	"go.uber.org/zap"
+	"gocloud.dev/pubsub/driver"
+	"gocloud.dev/pubsub/mempubsub"
+)
+
+// fakeSender is a fake fcmSender that returns one queued BatchResponse (and
+// optional error) per call, in order, so tests can script what the "FCM
+// backend" does across an initial send and any retries without needing
+// real Firebase credentials or network access.
+type fakeSender struct {
+	responses   []*messaging.BatchResponse
+	errs        []error
+	calls       [][]*messaging.Message
+	dryRunCalls int
+}
+
+func (f *fakeSender) SendAll(_ context.Context, messages []*messaging.Message) (*messaging.BatchResponse, error) {
+	return f.respond(messages)
+}
+
+func (f *fakeSender) SendAllDryRun(_ context.Context, messages []*messaging.Message) (*messaging.BatchResponse, error) {
+	f.dryRunCalls++
+	return f.respond(messages)
+}
+
+func (f *fakeSender) respond(messages []*messaging.Message) (*messaging.BatchResponse, error) {
+	i := len(f.calls)
+	f.calls = append(f.calls, messages)
+	if i >= len(f.responses) {
+		return nil, errors.New("fakeSender: no response queued for this call")
+	}
+	var err error
+	if i < len(f.errs) {
+		err = f.errs[i]
+	}
+	return f.responses[i], err
+}
+
+func messageBody(token string) []byte {
+	return []byte(`{"token":"` + token + `"}`)
+}
+
+func newTestLogger() *zap.Logger {
+	return zap.NewNop()
+}
+
+// TestSendBatch_DeadLetterMatrix covers a single mixed batch with a success,
+// a non-terminal failure, and a terminal failure, against a configured
+// DeadLetterTopic.
+func TestSendBatch_DeadLetterMatrix(t *testing.T) {
+	ctx := context.Background()
+	dlqTopic := mempubsub.NewTopic()
+	defer dlqTopic.Shutdown(ctx)
+	dlqSub := mempubsub.NewSubscription(dlqTopic, time.Minute)
+	defer dlqSub.Shutdown(ctx)
+
+	nonTerminalErr := errors.New("transient backend hiccup")
+	terminalErr := errors.New("token permanently invalid")
+
+	sender := &fakeSender{
+		responses: []*messaging.BatchResponse{
+			{
+				Responses: []*messaging.SendResponse{
+					{Success: true, MessageID: "msg-ok"},
+					{Success: false, Error: nonTerminalErr},
+					{Success: false, Error: terminalErr},
+				},
+				SuccessCount: 1,
+				FailureCount: 2,
+			},
+		},
+	}
+
+	var afterSendCount, onErrorCount int
+	opts := &TopicOptions{
+		Logger:          newTestLogger(),
+		DeadLetterTopic: dlqTopic,
+		TerminalErrorClassifier: func(err error) bool {
+			return errors.Is(err, terminalErr)
+		},
+		OnError: func(*messaging.SendResponse, error) {
+			onErrorCount++
+		},
+	}
+	topic := &fcmTopic{client: sender, opts: opts}
+
+	dms := []*driver.Message{
+		{
+			Body: messageBody("tok-ok"),
+			AfterSend: func(asFunc func(interface{}) bool) error {
+				afterSendCount++
+				return nil
+			},
+		},
+		{Body: messageBody("tok-transient"), Metadata: map[string]string{"k": "v"}},
+		{Body: messageBody("tok-terminal")},
+	}
+
+	err := topic.SendBatch(ctx, dms)
+	if err == nil {
+		t.Fatal("expected SendBatch to surface the non-terminal failure, got nil")
+	}
+	if !errors.Is(err, nonTerminalErr) {
+		t.Fatalf("expected the non-terminal (not dead-lettered) failure to be the representative error, got %v", err)
+	}
+	if afterSendCount != 1 {
+		t.Fatalf("expected AfterSend to fire once for the successful message, got %d", afterSendCount)
+	}
+	if onErrorCount != 2 {
+		t.Fatalf("expected OnError to fire for both failed messages, got %d", onErrorCount)
+	}
+
+	msg, err := dlqSub.Receive(ctx)
+	if err != nil {
+		t.Fatalf("expected the terminal failure to be dead-lettered, got error receiving: %v", err)
+	}
+	msg.Ack()
+
+	var envelope DeadLetterEnvelope
+	if err := json.Unmarshal(msg.Body, &envelope); err != nil {
+		t.Fatalf("failed to unmarshal DeadLetterEnvelope: %v", err)
+	}
+	if envelope.Token != "tok-terminal" {
+		t.Errorf("envelope.Token = %q, want %q", envelope.Token, "tok-terminal")
+	}
+	if envelope.AttemptCount != 1 {
+		t.Errorf("envelope.AttemptCount = %d, want 1 (no retries occurred)", envelope.AttemptCount)
+	}
+	if envelope.FCMError != terminalErr.Error() {
+		t.Errorf("envelope.FCMError = %q, want %q", envelope.FCMError, terminalErr.Error())
+	}
+}
+
+// TestSendBatch_NoDeadLetterTopic_SynchronousFallback verifies that, absent
+// a configured DeadLetterTopic, a terminally-classified failure still
+// surfaces synchronously as the batch's error instead of being silently
+// dropped.
+func TestSendBatch_NoDeadLetterTopic_SynchronousFallback(t *testing.T) {
+	ctx := context.Background()
+	terminalErr := errors.New("token permanently invalid")
+
+	sender := &fakeSender{
+		responses: []*messaging.BatchResponse{
+			{
+				Responses:    []*messaging.SendResponse{{Success: false, Error: terminalErr}},
+				FailureCount: 1,
+			},
+		},
+	}
+
+	var onErrorCount int
+	opts := &TopicOptions{
+		Logger: newTestLogger(),
+		TerminalErrorClassifier: func(err error) bool {
+			return errors.Is(err, terminalErr)
+		},
+		OnError: func(*messaging.SendResponse, error) {
+			onErrorCount++
+		},
+	}
+	topic := &fcmTopic{client: sender, opts: opts}
+
+	err := topic.SendBatch(ctx, []*driver.Message{{Body: messageBody("tok-terminal")}})
+	if !errors.Is(err, terminalErr) {
+		t.Fatalf("expected the terminal failure to surface synchronously with no DeadLetterTopic, got %v", err)
+	}
+	if onErrorCount != 1 {
+		t.Fatalf("expected OnError to still fire once, got %d", onErrorCount)
+	}
+}
+
+func TestIsTerminalError_MaxDeliveryAttempts(t *testing.T) {
+	genericErr := errors.New("boom")
+	tests := []struct {
+		name                string
+		maxDeliveryAttempts int
+		attemptCount        int
+		want                bool
+	}{
+		{name: "unset budget, non-classified error is not terminal", maxDeliveryAttempts: 0, attemptCount: 5, want: false},
+		{name: "budget not yet exhausted", maxDeliveryAttempts: 3, attemptCount: 2, want: false},
+		{name: "budget exactly exhausted", maxDeliveryAttempts: 3, attemptCount: 3, want: true},
+		{name: "budget exceeded", maxDeliveryAttempts: 3, attemptCount: 4, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			topic := &fcmTopic{opts: &TopicOptions{MaxDeliveryAttempts: tt.maxDeliveryAttempts}}
+			if got := topic.isTerminalError(genericErr, tt.attemptCount); got != tt.want {
+				t.Errorf("isTerminalError(attemptCount=%d) with MaxDeliveryAttempts=%d = %v, want %v",
+					tt.attemptCount, tt.maxDeliveryAttempts, got, tt.want)
+			}
+		})
+	}
+}